@@ -0,0 +1,226 @@
+// Package archive provides pluggable codecs for the executor's artifact archives. Every
+// codec converts between its own encoding (gzip/zstd/xz-compressed tar, a bare tar, or a
+// zip file) and a plain tar byte stream, so the rest of the executor only ever has to deal
+// with tar.Reader/tar.Writer regardless of which codec produced or will consume the bytes.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Codec converts between a compressed/archived byte stream and a plain tar stream.
+type Codec interface {
+	// Extension is the codec's canonical file extension, e.g. ".tar.gz".
+	Extension() string
+	// NewReader wraps r (an encoded stream) and yields a plain tar-formatted stream.
+	NewReader(r io.Reader) (io.Reader, error)
+	// NewWriter wraps w, accepting a plain tar-formatted stream written to the returned
+	// writer and encoding it into w as it goes. The caller must Close the writer to
+	// flush any trailing data.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// Tar is the uncompressed tar codec: reads and writes are passed through unmodified.
+var Tar Codec = tarCodec{}
+
+// Gzip wraps a tar stream in gzip compression (".tar.gz" / ".tgz").
+var Gzip Codec = gzipCodec{}
+
+// Zstd wraps a tar stream in zstd compression.
+var Zstd Codec = zstdCodec{level: zstd.SpeedDefault}
+
+// Xz wraps a tar stream in xz compression.
+var Xz Codec = xzCodec{}
+
+// Zip re-muxes between a zip archive and an equivalent plain tar stream, so zip-output
+// artifacts can be produced/consumed through the same tar-walking code as every other
+// codec.
+var Zip Codec = zipCodec{}
+
+// NewZstd returns a Zstd codec writing at the given compression level.
+func NewZstd(level zstd.EncoderLevel) Codec {
+	return zstdCodec{level: level}
+}
+
+var magicTable = []struct {
+	magic []byte
+	codec Codec
+}{
+	{[]byte{0x1f, 0x8b}, Gzip},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, Zstd},
+	{[]byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}, Xz},
+	{[]byte{0x50, 0x4b, 0x03, 0x04}, Zip},
+}
+
+// Detect sniffs the codec of r by magic bytes rather than by file extension, so a
+// mis-named file (e.g. a ".tar.gz" that is actually zstd) is still decoded correctly. It
+// returns the detected codec along with a reader that still yields the full stream
+// (including the bytes consumed while peeking). Unrecognized input is assumed to be a bare
+// tar.
+func Detect(r io.Reader) (Codec, io.Reader, error) {
+	br := bufio.NewReaderSize(r, 512)
+	header, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	for _, m := range magicTable {
+		if len(header) >= len(m.magic) && bytes.Equal(header[:len(m.magic)], m.magic) {
+			return m.codec, br, nil
+		}
+	}
+	return Tar, br, nil
+}
+
+type tarCodec struct{}
+
+func (tarCodec) Extension() string                             { return ".tar" }
+func (tarCodec) NewReader(r io.Reader) (io.Reader, error)      { return r, nil }
+func (tarCodec) NewWriter(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil }
+
+type gzipCodec struct{}
+
+func (gzipCodec) Extension() string { return ".tar.gz" }
+
+func (gzipCodec) NewReader(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+func (gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+type zstdCodec struct {
+	level zstd.EncoderLevel
+}
+
+func (zstdCodec) Extension() string { return ".tar.zst" }
+
+func (zstdCodec) NewReader(r io.Reader) (io.Reader, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
+
+func (c zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(c.level))
+}
+
+type xzCodec struct{}
+
+func (xzCodec) Extension() string { return ".tar.xz" }
+
+func (xzCodec) NewReader(r io.Reader) (io.Reader, error) {
+	return xz.NewReader(r)
+}
+
+func (xzCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return xz.NewWriter(w)
+}
+
+type zipCodec struct{}
+
+func (zipCodec) Extension() string { return ".zip" }
+
+// NewReader buffers the zip (which requires random access to its trailing central
+// directory) and re-emits its entries as a plain tar stream.
+func (zipCodec) NewReader(r io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("archive: not a valid zip: %w", err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range zr.File {
+		hdr, err := tar.FileInfoHeader(f.FileInfo(), "")
+		if err != nil {
+			return nil, err
+		}
+		hdr.Name = f.Name
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(tw, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// zipWriter buffers the plain tar stream written to it and, on Close, re-muxes every tar
+// entry into a zip archive written to the underlying writer.
+type zipWriter struct {
+	buf bytes.Buffer
+	dst io.Writer
+}
+
+func (zw *zipWriter) Write(p []byte) (int, error) { return zw.buf.Write(p) }
+
+func (zw *zipWriter) Close() error {
+	zwriter := zip.NewWriter(zw.dst)
+	tr := tar.NewReader(&zw.buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fh, err := zip.FileInfoHeader(hdr.FileInfo())
+		if err != nil {
+			return err
+		}
+		fh.Name = hdr.Name
+		fh.Method = zip.Deflate
+		w, err := zwriter.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		if hdr.FileInfo().IsDir() {
+			continue
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return err
+		}
+	}
+	return zwriter.Close()
+}
+
+func (zipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &zipWriter{dst: w}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
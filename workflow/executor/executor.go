@@ -0,0 +1,320 @@
+package executor
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	"github.com/argoproj/argo/workflow/executor/archive"
+	"github.com/argoproj/argo/workflow/executor/safepath"
+)
+
+// WorkflowExecutor is program which runs as the init/wait container
+type WorkflowExecutor struct {
+	PodName            string
+	PodUID             string
+	Template           wfv1.Template
+	ClientSet          kubernetes.Interface
+	Namespace          string
+	PodAnnotationsPath string
+	ExecutionControl   *wfv1.ExecutionControl
+	RuntimeExecutor    ContainerRuntimeExecutor
+
+	// mainContainerID is the container id of the main container
+	mainContainerID string
+}
+
+// ContainerRuntimeExecutor is the interface through which the WorkflowExecutor interacts with
+// the underlying container runtime (docker, kubelet, pns, etc...)
+type ContainerRuntimeExecutor interface {
+	// GetFileContents returns the file contents of a file in a container as a string
+	GetFileContents(containerID string, sourcePath string) (string, error)
+
+	// CopyFile copies a source file in a container to a local path
+	CopyFile(containerID string, sourcePath string, destPath string) error
+
+	// Kill a list of containers first with a SIGTERM then with a SIGKILL after a grace period
+	Kill(containerIDs []string) error
+
+	// WaitInit is called before calling Wait() to give a chance for pre-wait initialization
+	WaitInit() error
+
+	// Wait waits for the container to complete
+	Wait(containerID string) error
+}
+
+// isBaseImagePath determines if a path is coming from a base image layer versus a shared volumeMount.
+// We do this by iterating over the output artifacts paths, and comparing it against the artifact
+// paths which were input volume mounts (copied from inputs), or the container's declared
+// volumeMounts. If the path is under any of these mount points, it is not considered part of the
+// base image, but instead, the shared emptyDir/volumeMount.
+func (we *WorkflowExecutor) isBaseImagePath(path string) bool {
+	// A glob/wildcard output path (e.g. "/data/**/*.log") doesn't name a concrete file, so
+	// compare against the directory prefix up to the first wildcard metacharacter instead
+	// of the literal path.
+	path = globOutputDir(path)
+
+	// Check if the output is in one of the input artifact paths.
+	for _, inArt := range we.Template.Inputs.Artifacts {
+		if path == inArt.Path || strings.HasPrefix(path, inArt.Path+"/") {
+			return false
+		}
+	}
+	if we.Template.Container == nil {
+		return true
+	}
+	for _, mnt := range we.Template.Container.VolumeMounts {
+		// mnt.MountPath is the container-visible root of the mount regardless of whether
+		// mnt.SubPath exposes the whole volume or only a subdirectory of it, so a single
+		// prefix check covers both cases.
+		if path == mnt.MountPath || strings.HasPrefix(path, mnt.MountPath+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// globOutputDir returns the directory prefix of path up to (but not including) its first
+// glob metacharacter. Paths without any metacharacter are returned unchanged.
+func globOutputDir(path string) string {
+	idx := strings.IndexAny(path, "*?[")
+	if idx < 0 {
+		return path
+	}
+	return filepath.Dir(path[:idx])
+}
+
+// hasGlobMeta reports whether path contains any glob metacharacter, including the "**"
+// recursive-match marker used by doublestar.
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// isTarball returns whether or not the file is a compressed tar archive (tar+gzip,
+// tar+zstd, or tar+xz). The codec is sniffed from the file's magic bytes rather than
+// inferred from its extension, so a mis-named file is still classified correctly. A bare
+// (uncompressed) .tar or a .zip is not considered a "tarball" here; those are handled via
+// untar's plain-tar path and unzip respectively.
+func isTarball(filePath string) (bool, error) {
+	log.Infof("Detecting if %s is a tarball", filePath)
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	codec, rest, err := archive.Detect(f)
+	if err != nil {
+		return false, err
+	}
+	if codec == archive.Tar || codec == archive.Zip {
+		return false, nil
+	}
+	dr, err := codec.NewReader(rest)
+	if err != nil {
+		return false, nil
+	}
+	_, err = tar.NewReader(dr).Next()
+	return err == nil, nil
+}
+
+// chmod changes the permissions of a path, optionally recursing into subdirectories. It
+// resolves path with the safepath walker rooted at path's parent directory so that a
+// symlink planted at path (or, when recursing, anywhere beneath it) is rejected rather
+// than followed into a chmod outside the mounted artifact directory.
+func chmod(path string, mode int32, recurse bool) error {
+	parent := filepath.Dir(path)
+	base := filepath.Base(path)
+
+	root, err := safepath.Root(parent)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	f, err := safepath.OpenAtNoFollow(root, base)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := safepath.ChmodAt(f, os.FileMode(mode)); err != nil {
+		return err
+	}
+	if !recurse {
+		return nil
+	}
+
+	subRoot, err := safepath.Root(path)
+	if err != nil {
+		return err
+	}
+	defer subRoot.Close()
+
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == path {
+			return nil
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		child, err := safepath.OpenAtNoFollow(subRoot, rel)
+		if err != nil {
+			return err
+		}
+		defer child.Close()
+		return safepath.ChmodAt(child, os.FileMode(mode))
+	})
+}
+
+// SaveParameters will save the content in the specified file path as output parameter value
+func (we *WorkflowExecutor) SaveParameters() error {
+	log.Infof("Saving output parameters")
+	for i, param := range we.Template.Outputs.Parameters {
+		if param.ValueFrom == nil || param.ValueFrom.Path == "" {
+			continue
+		}
+		log.Infof("Saving path output parameter: %s", param.Name)
+		output, err := we.RuntimeExecutor.GetFileContents(we.mainContainerID, param.ValueFrom.Path)
+		if err != nil {
+			if param.ValueFrom.Default != nil {
+				output = *param.ValueFrom.Default
+			} else {
+				return err
+			}
+		} else {
+			output = strings.TrimSuffix(output, "\n")
+		}
+		we.Template.Outputs.Parameters[i].Value = &output
+	}
+	return nil
+}
+
+// LoadArtifacts prepares a container's input artifacts. An artifact with a SubPath is
+// bind-mounted read-only onto its own per-step directory (see bindMountSubPath) rather
+// than handed to the container runtime as a bare path, so a symlink swap after resolution
+// cannot redirect the main container onto a different inode than the one that was
+// validated.
+func (we *WorkflowExecutor) LoadArtifacts() error {
+	for _, art := range we.Template.Inputs.Artifacts {
+		if art.SubPath == "" {
+			continue
+		}
+		log.Infof("Resolving subpath for input artifact: %s", art.Name)
+		mounted, err := bindMountSubPath(we.PodUID, "main", art.Path, art)
+		if err != nil {
+			return err
+		}
+		log.Infof("Input artifact %s subpath bind-mounted at %s", art.Name, mounted)
+	}
+	return nil
+}
+
+// SaveArtifacts saves all of a container's output artifacts. An artifact with a SubPath
+// is bind-mounted read-only (see bindMountSubPath) before being archived, so that a
+// symlink swap racing the archive step cannot redirect it onto a different inode after
+// resolution.
+func (we *WorkflowExecutor) SaveArtifacts() error {
+	for _, art := range we.Template.Outputs.Artifacts {
+		log.Infof("Saving output artifact: %s", art.Name)
+		if art.SubPath != "" {
+			mounted, err := bindMountSubPath(we.PodUID, "main", art.Path, art)
+			if err != nil {
+				return err
+			}
+			defer unmountSubPath(mounted) //nolint:errcheck
+			art.Path = mounted
+		}
+		if err := we.saveArtifact(art); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (we *WorkflowExecutor) saveArtifact(art wfv1.Artifact) error {
+	if hasGlobMeta(art.Path) {
+		return we.SaveWildcardArtifact(art, globOutputDir(art.Path))
+	}
+	if we.isBaseImagePath(art.Path) {
+		// Copy out of the base image layer via the container runtime. The copy at tmpPath
+		// is this artifact's saved output - the same role os.TempDir() plays for
+		// SaveWildcardArtifact - so unlike a scratch file it must survive the call.
+		tmpPath := filepath.Join(os.TempDir(), filepath.Base(art.Path))
+		if err := we.RuntimeExecutor.CopyFile(we.mainContainerID, art.Path, tmpPath); err != nil {
+			return err
+		}
+		log.Infof("Saved output artifact %s to %s", art.Name, tmpPath)
+		return nil
+	}
+	// art.Path lives on a shared volumeMount that the user's container also writes to, so
+	// it is resolved through safepath before we ever stat or archive it: a container that
+	// swapped the artifact path for a symlink into the node filesystem must not let the
+	// (privileged) executor read or tar anything outside the mount root.
+	root, err := safepath.Root(filepath.Dir(art.Path))
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	f, err := safepath.OpenAtNoFollow(root, filepath.Base(art.Path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := safepath.StatAt(f)
+	if err != nil {
+		return err
+	}
+
+	// Archive the resolved file (or directory tree) the same way SaveWildcardArtifact
+	// does, through the same safepath-validated TarAt/zip writers, so a plain output
+	// artifact is staged for upload rather than merely validated and discarded.
+	match := []wildcardMatch{{relPath: filepath.Base(art.Path), file: f, info: info}}
+	dest := filepath.Join(os.TempDir(), art.Name+wildcardArchiveExt(art))
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if art.Archive != nil && art.Archive.Zip != nil {
+		err = writeWildcardZip(out, match)
+	} else {
+		err = writeWildcardTar(out, match)
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("Saved output artifact %s to %s", art.Name, dest)
+	return nil
+}
+
+// ensureDirExists creates dir if it does not already exist
+func ensureDirExists(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return os.MkdirAll(dir, 0755)
+	}
+	return nil
+}
+
+// runCommand is a helper to shell out to a command, returning combined output
+func runCommand(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("`%s %s` failed: %s: %w", name, strings.Join(args, " "), string(out), err)
+	}
+	return string(out), nil
+}
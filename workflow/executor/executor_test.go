@@ -1,9 +1,11 @@
 package executor
 
 import (
+	"archive/tar"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -185,6 +187,11 @@ func TestIsTarball(t *testing.T) {
 		{"testdata/file.gz", false, false},
 		{"testdata/file.tar.gz", true, false},
 		{"testdata/file.tgz", true, false},
+		{"testdata/file.tar.zst", true, false},
+		{"testdata/file.tar.xz", true, false},
+		// named like a gzip tarball, but is actually zstd-compressed: sniffing by magic
+		// bytes must win over the extension.
+		{"testdata/file-mismatched.tar.gz", true, false},
 		{"testdata/not-found", false, true},
 	}
 
@@ -218,21 +225,64 @@ func TestUnzip(t *testing.T) {
 }
 
 func TestUntar(t *testing.T) {
-	tarPath := "testdata/file.tar.gz"
-	destPath := "testdata/untarredFile"
+	tests := []string{
+		"testdata/file.tar",
+		"testdata/file.tar.gz",
+		"testdata/file.tgz",
+		"testdata/file.tar.zst",
+		"testdata/file.tar.xz",
+	}
 
-	// test
-	err := untar(tarPath, destPath)
+	for _, tarPath := range tests {
+		destPath := "testdata/untarredFile"
+
+		// test
+		err := untar(tarPath, destPath)
+		assert.NoError(t, err, tarPath)
+
+		// check untarred file
+		fileInfo, err := os.Stat(destPath)
+		assert.NoError(t, err, tarPath)
+		assert.True(t, fileInfo.Mode().IsRegular(), tarPath)
+
+		// cleanup
+		err = os.Remove(destPath)
+		assert.NoError(t, err, tarPath)
+	}
+}
+
+// TestSaveArtifactSharedVolumeMount guards against saveArtifact's non-wildcard,
+// shared-volumeMount path validating an artifact through safepath and then discarding it
+// instead of staging it for upload, the same way SaveWildcardArtifact stages its archive.
+func TestSaveArtifactSharedVolumeMount(t *testing.T) {
+	tempDir, err := ioutil.TempDir("testdata", "savearartifact-test")
 	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
 
-	// check untarred file
-	fileInfo, err := os.Stat(destPath)
+	artPath := filepath.Join(tempDir, "output.txt")
+	assert.NoError(t, ioutil.WriteFile(artPath, []byte("hello"), 0644))
+
+	art := wfv1.Artifact{Name: "out", Path: artPath}
+	we := WorkflowExecutor{
+		Template: wfv1.Template{
+			Container: &corev1.Container{
+				VolumeMounts: []corev1.VolumeMount{{Name: "workdir", MountPath: tempDir}},
+			},
+			Outputs: wfv1.Outputs{Artifacts: []wfv1.Artifact{art}},
+		},
+	}
+
+	assert.NoError(t, we.saveArtifact(art))
+
+	dest := filepath.Join(os.TempDir(), "out.tar")
+	defer os.Remove(dest)
+	f, err := os.Open(dest)
 	assert.NoError(t, err)
-	assert.True(t, fileInfo.Mode().IsRegular())
+	defer f.Close()
 
-	// cleanup
-	err = os.Remove(destPath)
+	hdr, err := tar.NewReader(f).Next()
 	assert.NoError(t, err)
+	assert.Equal(t, "output.txt", hdr.Name)
 }
 
 func TestChmod(t *testing.T) {
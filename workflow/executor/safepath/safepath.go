@@ -0,0 +1,491 @@
+// Package safepath provides symlink-safe file operations rooted at a volumeMount.
+//
+// A caller opens a root directory once (typically the artifact volumeMount) and then
+// resolves every subsequent path component with openat(O_NOFOLLOW|O_PATH), rejecting
+// symlinks as it walks rather than trusting a single os.Stat/os.Chmod against the final
+// path. This closes the TOCTOU window a malicious or buggy container can exploit by
+// dropping a symlink (e.g. "inner -> /etc") under an artifact output directory that the
+// executor later chmods, stats, or tars while running with elevated privileges.
+package safepath
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrEscapesRoot is returned whenever a path (or a symlink encountered while walking one)
+// would resolve outside of the root a File was opened against.
+var ErrEscapesRoot = errors.New("safepath: path escapes root")
+
+// File is a path resolved relative to a root directory, represented as the directory file
+// descriptor that contains it plus the final path component, plus an independently-opened
+// O_PATH descriptor for the leaf itself (leafFd). Holding (dirfd, basename) instead of a
+// resolved absolute path means every *at operation re-validates against the same
+// denominated directory, so a rename/symlink swap after resolution cannot redirect it;
+// holding leafFd additionally means the leaf's *content* can be read (via Path/Open) by
+// redereferencing that exact already-validated descriptor rather than looking basename up
+// again, which would reopen the same TOCTOU window a second time.
+type File struct {
+	root     *os.File
+	dirfd    int
+	basename string
+	leafFd   int
+}
+
+// Close releases the file descriptors held by the File.
+func (f *File) Close() error {
+	var firstErr error
+	if err := unix.Close(f.leafFd); err != nil {
+		firstErr = err
+	}
+	if f.dirfd != int(f.root.Fd()) {
+		if err := unix.Close(f.dirfd); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Root opens dir and returns it as the root of a safepath walk. The caller must Close the
+// returned handle (via the File's parent directory, i.e. passing "." to OpenAtNoFollow and
+// then closing that) once it is no longer needed.
+func Root(dir string) (*os.File, error) {
+	f, err := os.OpenFile(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("safepath: root %q is not a directory", dir)
+	}
+	return f, nil
+}
+
+// OpenAtNoFollow resolves rel (which may contain multiple path components) starting from
+// root, walking one component at a time with openat(O_NOFOLLOW|O_PATH). Any symlink or
+// ".."-escape encountered along the way fails closed with ErrEscapesRoot.
+func OpenAtNoFollow(root *os.File, rel string) (*File, error) {
+	if filepath.IsAbs(rel) {
+		return nil, fmt.Errorf("%w: %q is absolute", ErrEscapesRoot, rel)
+	}
+	clean := filepath.Clean(rel)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return nil, fmt.Errorf("%w: %q traverses above root", ErrEscapesRoot, rel)
+	}
+
+	parts := strings.Split(clean, string(filepath.Separator))
+	curFd := int(root.Fd())
+	opened := false
+	for i, part := range parts {
+		if part == "" || part == "." {
+			continue
+		}
+		last := i == len(parts)-1
+		flags := unix.O_NOFOLLOW | unix.O_PATH
+		if !last {
+			flags |= unix.O_DIRECTORY
+		}
+		nextFd, err := openAt2OrFallback(curFd, part, flags)
+		if err != nil {
+			if opened {
+				unix.Close(curFd)
+			}
+			return nil, fmt.Errorf("%w: %q: %v", ErrEscapesRoot, rel, err)
+		}
+		if opened {
+			unix.Close(curFd)
+		}
+		curFd = nextFd
+		opened = true
+	}
+
+	if !opened {
+		// rel resolved to the root itself (e.g. "." or "").
+		dupFd, err := unix.Dup(int(root.Fd()))
+		if err != nil {
+			return nil, err
+		}
+		leafFd, err := unix.Dup(int(root.Fd()))
+		if err != nil {
+			unix.Close(dupFd)
+			return nil, err
+		}
+		return &File{root: root, dirfd: dupFd, basename: ".", leafFd: leafFd}, nil
+	}
+
+	// curFd currently refers to the resolved leaf opened O_PATH; re-open its parent so
+	// callers can do fstatat/fchmodat(dirfd, basename, ...) against it.
+	unix.Close(curFd)
+	parent, base := filepath.Split(clean)
+	var parentFd int
+	if parent != "" {
+		pf, err := resolveDir(root, parent)
+		if err != nil {
+			return nil, err
+		}
+		parentFd = pf
+	} else {
+		// The leaf sits directly under root. Dup root's fd rather than reusing it
+		// verbatim: the returned File must own a dirfd independent of root's lifetime, so
+		// a caller that closes root as soon as it has handed off the File (the common
+		// pattern: open root, resolve, close root, keep using the File) doesn't yank the
+		// fd out from under the File it's still holding.
+		dupFd, err := unix.Dup(int(root.Fd()))
+		if err != nil {
+			return nil, err
+		}
+		parentFd = dupFd
+	}
+	// Validate the leaf itself is not a symlink before handing back the handle, and keep
+	// the validating descriptor open: it is the File's only race-free handle onto the
+	// leaf's content, since a further *at(dirfd, basename, ...) call would look basename
+	// up again and could be raced by a rename/symlink swap in between.
+	leafFd, err := openAt2OrFallback(parentFd, base, unix.O_NOFOLLOW|unix.O_PATH)
+	if err != nil {
+		unix.Close(parentFd)
+		return nil, fmt.Errorf("%w: %q: %v", ErrEscapesRoot, rel, err)
+	}
+
+	return &File{root: root, dirfd: parentFd, basename: base, leafFd: leafFd}, nil
+}
+
+// resolveDir walks rel (a directory, must end in a separator or be empty) from root and
+// returns an owned fd to the final directory.
+func resolveDir(root *os.File, rel string) (int, error) {
+	clean := filepath.Clean(rel)
+	if clean == "." {
+		return unix.Dup(int(root.Fd()))
+	}
+	parts := strings.Split(clean, string(filepath.Separator))
+	curFd := int(root.Fd())
+	opened := false
+	for _, part := range parts {
+		if part == "" || part == "." {
+			continue
+		}
+		nextFd, err := openAt2OrFallback(curFd, part, unix.O_NOFOLLOW|unix.O_DIRECTORY|unix.O_PATH)
+		if err != nil {
+			if opened {
+				unix.Close(curFd)
+			}
+			return -1, err
+		}
+		if opened {
+			unix.Close(curFd)
+		}
+		curFd = nextFd
+		opened = true
+	}
+	return curFd, nil
+}
+
+// StatAt lstat's the File without following a final symlink swap, using fstatat with
+// AT_SYMLINK_NOFOLLOW against the held directory descriptor.
+func StatAt(f *File) (os.FileInfo, error) {
+	var stat unix.Stat_t
+	if err := unix.Fstatat(f.dirfd, f.basename, &stat, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return nil, fmt.Errorf("%w: fstatat %q: %v", ErrEscapesRoot, f.basename, err)
+	}
+	if stat.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return nil, fmt.Errorf("%w: %q is a symlink", ErrEscapesRoot, f.basename)
+	}
+	return os.Stat(f.Path())
+}
+
+// ChmodAt changes the mode of the File using fchmodat with AT_SYMLINK_NOFOLLOW so a
+// symlink dropped at the target path cannot cause the executor to chmod something
+// outside the mounted artifact directory.
+func ChmodAt(f *File, mode os.FileMode) error {
+	if _, err := StatAt(f); err != nil {
+		return err
+	}
+	return unix.Fchmodat(f.dirfd, f.basename, uint32(mode), unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// resolveParentFd resolves dir - the directory-component prefix returned by filepath.Split,
+// possibly empty - relative to root, rejecting any symlink found along the way via
+// OpenAtNoFollow. The returned fd must be closed by the caller only when owned is true; an
+// empty dir resolves to root itself, which the caller does not own.
+func resolveParentFd(root *os.File, dir string) (fd int, owned bool, err error) {
+	if dir == "" || dir == "./" {
+		return int(root.Fd()), false, nil
+	}
+	pf, err := OpenAtNoFollow(root, dir)
+	if err != nil {
+		return -1, false, err
+	}
+	defer pf.Close()
+	fd, err = unix.Openat(pf.dirfd, pf.basename, unix.O_DIRECTORY|unix.O_PATH, 0)
+	if err != nil {
+		return -1, false, fmt.Errorf("%w: %v", ErrEscapesRoot, err)
+	}
+	return fd, true, nil
+}
+
+// CreateAt safely creates (or truncates) rel beneath root for writing, resolving every
+// intermediate directory with OpenAtNoFollow and then creating the leaf with
+// openat(O_NOFOLLOW|O_CREAT|O_TRUNC). Because O_NOFOLLOW also applies to the final
+// component of an O_CREAT open, a pre-existing symlink at rel causes the open to fail
+// with ELOOP instead of writing through it.
+func CreateAt(root *os.File, rel string, mode os.FileMode) (*os.File, error) {
+	dir, base := filepath.Split(filepath.Clean(rel))
+	parentFd, owned, err := resolveParentFd(root, dir)
+	if err != nil {
+		return nil, err
+	}
+	if owned {
+		defer unix.Close(parentFd)
+	}
+	fd, err := unix.Openat(parentFd, base, unix.O_NOFOLLOW|unix.O_CREAT|unix.O_WRONLY|unix.O_TRUNC, uint32(mode))
+	if err != nil {
+		return nil, fmt.Errorf("%w: openat %q: %v", ErrEscapesRoot, rel, err)
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(root.Name(), rel)), nil
+}
+
+// MkdirAllAt ensures every directory component of rel exists beneath root, creating any
+// that are missing with mkdirat. Each component is resolved one at a time with
+// openat(O_NOFOLLOW|O_PATH) before deciding whether it needs creating, so a symlink planted
+// at an earlier component is rejected rather than traversed the way a plain os.MkdirAll
+// would traverse it.
+func MkdirAllAt(root *os.File, rel string, mode os.FileMode) error {
+	clean := filepath.Clean(rel)
+	if clean == "." || clean == "" {
+		return nil
+	}
+	if filepath.IsAbs(rel) {
+		return fmt.Errorf("%w: %q is absolute", ErrEscapesRoot, rel)
+	}
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("%w: %q traverses above root", ErrEscapesRoot, rel)
+	}
+
+	parts := strings.Split(clean, string(filepath.Separator))
+	curFd := int(root.Fd())
+	opened := false
+	for _, part := range parts {
+		if part == "" || part == "." {
+			continue
+		}
+		nextFd, err := openAt2OrFallback(curFd, part, unix.O_NOFOLLOW|unix.O_DIRECTORY|unix.O_PATH)
+		if errors.Is(err, unix.ENOENT) {
+			if mkErr := unix.Mkdirat(curFd, part, uint32(mode)); mkErr != nil && !errors.Is(mkErr, unix.EEXIST) {
+				err = mkErr
+			} else {
+				nextFd, err = openAt2OrFallback(curFd, part, unix.O_NOFOLLOW|unix.O_DIRECTORY|unix.O_PATH)
+			}
+		}
+		if err != nil {
+			if opened {
+				unix.Close(curFd)
+			}
+			return fmt.Errorf("%w: %q: %v", ErrEscapesRoot, rel, err)
+		}
+		if opened {
+			unix.Close(curFd)
+		}
+		curFd = nextFd
+		opened = true
+	}
+	if opened {
+		unix.Close(curFd)
+	}
+	return nil
+}
+
+// SymlinkAt creates a symlink at rel (relative to root) pointing at target, resolving rel's
+// parent directory through safepath so a symlink planted at an intermediate component is
+// rejected rather than traversed to place the new symlink elsewhere.
+func SymlinkAt(root *os.File, rel string, target string) error {
+	dir, base := filepath.Split(filepath.Clean(rel))
+	parentFd, owned, err := resolveParentFd(root, dir)
+	if err != nil {
+		return err
+	}
+	if owned {
+		defer unix.Close(parentFd)
+	}
+	if err := unix.Symlinkat(target, parentFd, base); err != nil {
+		return fmt.Errorf("%w: symlinkat %q: %v", ErrEscapesRoot, rel, err)
+	}
+	return nil
+}
+
+// LinkAt creates a hardlink at newRel (relative to root) pointing at the already-extracted
+// file oldRel (also relative to root), resolving both paths' parent directories through
+// safepath so a symlink planted at an intermediate component of either path is rejected
+// rather than traversed.
+func LinkAt(root *os.File, oldRel, newRel string) error {
+	oldDir, oldBase := filepath.Split(filepath.Clean(oldRel))
+	oldParentFd, oldOwned, err := resolveParentFd(root, oldDir)
+	if err != nil {
+		return err
+	}
+	if oldOwned {
+		defer unix.Close(oldParentFd)
+	}
+
+	newDir, newBase := filepath.Split(filepath.Clean(newRel))
+	newParentFd, newOwned, err := resolveParentFd(root, newDir)
+	if err != nil {
+		return err
+	}
+	if newOwned {
+		defer unix.Close(newParentFd)
+	}
+
+	if err := unix.Linkat(oldParentFd, oldBase, newParentFd, newBase, 0); err != nil {
+		return fmt.Errorf("%w: linkat %q -> %q: %v", ErrEscapesRoot, newRel, oldRel, err)
+	}
+	return nil
+}
+
+// Path returns a /proc/self/fd path that directly dereferences f's already-validated leaf
+// descriptor (leafFd) rather than looking basename up again through its directory. Unlike
+// joining dirfd with basename, this performs no further by-name lookup, so reopening this
+// path (e.g. to read content, or as a bind-mount source) cannot be raced by a rename or
+// symlink swap that happens after the File was resolved. Valid only for the lifetime of the
+// File (i.e. until Close is called).
+func (f *File) Path() string {
+	return fmt.Sprintf("/proc/self/fd/%d", f.leafFd)
+}
+
+// TarAt walks the File (which must be a directory, or a single file) and writes every
+// regular file and directory beneath it into w, rejecting any symlink it encounters along
+// the way instead of silently following it. Each directory level is re-opened and listed
+// through its own file descriptor rather than by concatenating path strings, so a directory
+// swapped for a symlink after being listed can never be followed into - the same
+// openat(O_NOFOLLOW)-per-level guarantee OpenAtNoFollow and chmod's recursive walk already
+// give a single path, extended here across the whole tree.
+func TarAt(f *File, w *tar.Writer) error {
+	rootStat, err := StatAt(f)
+	if err != nil {
+		return err
+	}
+	if !rootStat.IsDir() {
+		// f.Path() dereferences f's own already-validated leaf descriptor, so this open
+		// cannot be raced by a rename/symlink swap the way a dirfd+basename lookup could.
+		src, err := os.Open(f.Path())
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		return addTarEntry(w, src, f.basename, rootStat)
+	}
+	return tarWalkDir(f.dirfd, f.basename, f.basename, w)
+}
+
+// tarWalkDir archives the directory named base under parentFd (already confirmed not to be
+// a symlink by the caller) as tarName, then recurses into its children. Every child is
+// opened once - with openat(O_NOFOLLOW) - and statted/read from that single descriptor,
+// rather than being looked up by name more than once, so a rename or symlink swap racing
+// the walk cannot redirect a later read to something other than what was just listed.
+func tarWalkDir(parentFd int, base, tarName string, w *tar.Writer) error {
+	dirFd, err := openAt2OrFallback(parentFd, base, unix.O_NOFOLLOW|unix.O_DIRECTORY)
+	if err != nil {
+		return fmt.Errorf("%w: opening directory %q: %v", ErrEscapesRoot, tarName, err)
+	}
+	dir := os.NewFile(uintptr(dirFd), tarName)
+	defer dir.Close()
+
+	dirInfo, err := dir.Stat()
+	if err != nil {
+		return err
+	}
+	if err := addTarEntry(w, nil, tarName, dirInfo); err != nil {
+		return err
+	}
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childName := filepath.Join(tarName, name)
+		var stat unix.Stat_t
+		if err := unix.Fstatat(dirFd, name, &stat, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return fmt.Errorf("%w: fstatat %q: %v", ErrEscapesRoot, childName, err)
+		}
+		if stat.Mode&unix.S_IFMT == unix.S_IFLNK {
+			return fmt.Errorf("%w: %q is a symlink", ErrEscapesRoot, childName)
+		}
+		if stat.Mode&unix.S_IFMT == unix.S_IFDIR {
+			if err := tarWalkDir(dirFd, name, childName, w); err != nil {
+				return err
+			}
+			continue
+		}
+
+		childFd, err := openAt2OrFallback(dirFd, name, unix.O_NOFOLLOW)
+		if err != nil {
+			return fmt.Errorf("%w: opening %q: %v", ErrEscapesRoot, childName, err)
+		}
+		child := os.NewFile(uintptr(childFd), childName)
+		childInfo, err := child.Stat()
+		if err != nil {
+			child.Close()
+			return err
+		}
+		err = addTarEntry(w, child, childName, childInfo)
+		child.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTarEntry writes info's tar header under name into w. For a regular file, src must be
+// the already-open descriptor info was statted from, so its content is streamed from the
+// exact fd that was just validated rather than by reopening name a second time.
+func addTarEntry(w *tar.Writer, src *os.File, name string, info os.FileInfo) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := w.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+// openAt2OrFallback opens name under dirFd with openat2(RESOLVE_BENEATH) when the running
+// kernel supports it (5.6+), falling back to plain openat(O_NOFOLLOW) otherwise. Either
+// way the caller still gets O_NOFOLLOW semantics; RESOLVE_BENEATH additionally rejects any
+// ".." component or absolute symlink target the kernel resolves mid-lookup, closing races
+// that a single openat(O_NOFOLLOW) call cannot.
+func openAt2OrFallback(dirFd int, name string, flags int) (int, error) {
+	how := unix.OpenHow{
+		Flags:   uint64(flags),
+		Mode:    0,
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS,
+	}
+	fd, err := unix.Openat2(dirFd, name, &how)
+	if err == nil {
+		return fd, nil
+	}
+	if !errors.Is(err, unix.ENOSYS) {
+		return -1, err
+	}
+	return unix.Openat(dirFd, name, flags, 0)
+}
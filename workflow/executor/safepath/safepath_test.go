@@ -0,0 +1,208 @@
+package safepath
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupRoot(t *testing.T) (string, *os.File) {
+	dir, err := ioutil.TempDir("", "safepath-test")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	root, err := Root(dir)
+	assert.NoError(t, err)
+	t.Cleanup(func() { root.Close() })
+	return dir, root
+}
+
+func TestOpenAtNoFollow(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(dir string)
+		rel     string
+		wantErr bool
+	}{
+		{
+			name: "plain file",
+			setup: func(dir string) {
+				assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), []byte("hi"), 0644))
+			},
+			rel:     "file",
+			wantErr: false,
+		},
+		{
+			name: "nested plain file",
+			setup: func(dir string) {
+				assert.NoError(t, os.MkdirAll(filepath.Join(dir, "a", "b"), 0755))
+				assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a", "b", "file"), []byte("hi"), 0644))
+			},
+			rel:     "a/b/file",
+			wantErr: false,
+		},
+		{
+			name: "symlink to /etc/passwd is rejected",
+			setup: func(dir string) {
+				assert.NoError(t, os.Symlink("/etc/passwd", filepath.Join(dir, "evil")))
+			},
+			rel:     "evil",
+			wantErr: true,
+		},
+		{
+			name: "symlinked intermediate directory is rejected",
+			setup: func(dir string) {
+				assert.NoError(t, os.MkdirAll(filepath.Join(dir, "real"), 0755))
+				assert.NoError(t, os.Symlink(filepath.Join(dir, "real"), filepath.Join(dir, "link")))
+				assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "real", "file"), []byte("hi"), 0644))
+			},
+			rel:     "link/file",
+			wantErr: true,
+		},
+		{
+			name:    "dot-dot heavy path is rejected",
+			setup:   func(dir string) {},
+			rel:     "../../../../etc/passwd",
+			wantErr: true,
+		},
+		{
+			name:    "absolute path is rejected",
+			setup:   func(dir string) {},
+			rel:     "/etc/passwd",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, root := setupRoot(t)
+			test.setup(dir)
+
+			f, err := OpenAtNoFollow(root, test.rel)
+			if test.wantErr {
+				assert.Error(t, err)
+				assert.True(t, errors.Is(err, ErrEscapesRoot))
+				return
+			}
+			assert.NoError(t, err)
+			defer f.Close()
+		})
+	}
+}
+
+func TestOpenAtNoFollowTOCTOU(t *testing.T) {
+	// A symlink planted after the initial directory listing, but before the final
+	// component is resolved, must still be rejected because each component is opened
+	// with O_NOFOLLOW individually rather than trusting a path computed up front.
+	dir, root := setupRoot(t)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "samedir"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "samedir", "inner"), []byte("hi"), 0644))
+
+	_, err := OpenAtNoFollow(root, "samedir/inner")
+	assert.NoError(t, err)
+
+	// simulate the rename-to-symlink race
+	assert.NoError(t, os.Remove(filepath.Join(dir, "samedir", "inner")))
+	assert.NoError(t, os.Symlink("/etc", filepath.Join(dir, "samedir", "inner")))
+
+	_, err = OpenAtNoFollow(root, "samedir/inner")
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrEscapesRoot))
+}
+
+func TestStatAtAndChmodAt(t *testing.T) {
+	dir, root := setupRoot(t)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), []byte("hi"), 0600))
+
+	f, err := OpenAtNoFollow(root, "file")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	info, err := StatAt(f)
+	assert.NoError(t, err)
+	assert.False(t, info.IsDir())
+
+	assert.NoError(t, ChmodAt(f, 0644))
+	info, err = StatAt(f)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
+
+func TestChmodAtRejectsSwappedSymlink(t *testing.T) {
+	dir, root := setupRoot(t)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), []byte("hi"), 0600))
+
+	f, err := OpenAtNoFollow(root, "file")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, "file")))
+	assert.NoError(t, os.Symlink("/etc/shadow", filepath.Join(dir, "file")))
+
+	err = ChmodAt(f, 0777)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrEscapesRoot))
+}
+
+func TestTarAt(t *testing.T) {
+	dir, root := setupRoot(t)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "tree", "sub"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tree", "top"), []byte("top"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tree", "sub", "nested"), []byte("nested"), 0644))
+
+	f, err := OpenAtNoFollow(root, "tree")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	assert.NoError(t, TarAt(f, w))
+	assert.NoError(t, w.Close())
+
+	got := map[string]string{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		content, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err)
+		got[hdr.Name] = string(content)
+	}
+	assert.Equal(t, map[string]string{
+		"tree/top":        "top",
+		"tree/sub/nested": "nested",
+	}, got)
+}
+
+func TestTarAtRejectsTOCTOUSymlinkSwap(t *testing.T) {
+	// A subdirectory swapped for a symlink after TarAt has already listed its parent, but
+	// before it re-opens this exact entry, must still be rejected - each directory level is
+	// opened with O_NOFOLLOW from its own fd rather than trusting a path computed up front.
+	dir, root := setupRoot(t)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "tree", "sub"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tree", "sub", "nested"), []byte("nested"), 0644))
+
+	f, err := OpenAtNoFollow(root, "tree")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	assert.NoError(t, os.RemoveAll(filepath.Join(dir, "tree", "sub")))
+	assert.NoError(t, os.Symlink("/etc", filepath.Join(dir, "tree", "sub")))
+
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	err = TarAt(f, w)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrEscapesRoot))
+}
@@ -0,0 +1,383 @@
+package executor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/argoproj/argo/workflow/executor/archive"
+	"github.com/argoproj/argo/workflow/executor/safepath"
+)
+
+// untar extracts a tar archive (optionally gzip, zstd, or xz compressed, sniffed by magic
+// bytes) to a destination path. A single-file archive is written directly to destPath (the
+// common case for a user specifying an exact output artifact path); a multi-entry archive
+// is extracted with destPath treated as the containing directory.
+//
+// Extraction is streamed rather than buffered into memory: the archive is decompressed
+// once, and each regular-file entry is spooled to a small temp file and handed off to a
+// pool of runtime.NumCPU() workers, so peak memory use stays bounded regardless of archive
+// size. Directories are created synchronously as their headers are read - tar entries list
+// a directory before its children, so this alone preserves creation order without a
+// separate topological pass. Hardlinks are deferred until every regular file has drained
+// from the worker pool, since a hardlink's target may not exist yet when its own header is
+// read. Every write target is re-resolved through safepath so a symlink embedded in the
+// archive, or one waiting at destPath, cannot redirect a write outside destPath's parent.
+func untar(tarPath string, destPath string) error {
+	singleFile, err := tarIsSingleFile(tarPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	codec, rest, err := archive.Detect(f)
+	if err != nil {
+		return err
+	}
+	dr, err := codec.NewReader(rest)
+	if err != nil {
+		return err
+	}
+
+	return streamUntar(dr, destPath, singleFile)
+}
+
+// tarIsSingleFile makes a cheap first pass over the archive's headers to decide whether
+// extraction should write directly to destPath or treat it as a directory: true only when
+// the archive holds exactly one entry and that entry isn't a directory. archive/tar's
+// Next() discards the remainder of the previous entry's content without our having to read
+// it, and the scan stops as soon as a second entry is seen, so the common case (a single
+// small output file) costs little beyond the one real extraction pass.
+func tarIsSingleFile(tarPath string) (bool, error) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	codec, rest, err := archive.Detect(f)
+	if err != nil {
+		return false, err
+	}
+	dr, err := codec.NewReader(rest)
+	if err != nil {
+		return false, err
+	}
+
+	tr := tar.NewReader(dr)
+	entries := 0
+	onlyEntryIsDir := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		entries++
+		if entries > 1 {
+			return false, nil
+		}
+		onlyEntryIsDir = hdr.Typeflag == tar.TypeDir
+	}
+	return entries == 1 && !onlyEntryIsDir, nil
+}
+
+// tarJob is handed from the single tar-reading goroutine to the worker pool: rel and mode
+// describe the entry's destination, and tmp holds its content already spooled to a temp
+// file so the reader can move on to the next header without waiting for the write to land.
+type tarJob struct {
+	rel  string
+	mode os.FileMode
+	tmp  string
+}
+
+// pendingLink is a hardlink entry whose target may not exist yet when its header is read;
+// it's recreated once the worker pool has drained every regular file.
+type pendingLink struct {
+	rel    string
+	target string
+}
+
+func streamUntar(r io.Reader, destPath string, singleFile bool) error {
+	destRoot, err := safepath.Root(filepath.Dir(destPath))
+	if err != nil {
+		return err
+	}
+	defer destRoot.Close()
+	destBase := filepath.Base(destPath)
+
+	if !singleFile {
+		if err := safepath.MkdirAllAt(destRoot, destBase, 0755); err != nil {
+			return err
+		}
+	}
+
+	workers := runtime.NumCPU()
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan tarJob, workers*2)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	recordErr := func(err error) {
+		if err == nil {
+			return
+		}
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				recordErr(writeTarJob(destRoot, job))
+			}
+		}()
+	}
+
+	var links []pendingLink
+	tr := tar.NewReader(r)
+
+	readErr := func() error {
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			rel, err := tarEntryRel(destBase, hdr.Name, singleFile)
+			if err != nil {
+				return err
+			}
+
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				if err := safepath.MkdirAllAt(destRoot, rel, os.FileMode(hdr.Mode)); err != nil {
+					return err
+				}
+			case tar.TypeLink:
+				target, err := tarEntryRel(destBase, hdr.Linkname, singleFile)
+				if err != nil {
+					return err
+				}
+				links = append(links, pendingLink{rel: rel, target: target})
+			case tar.TypeSymlink:
+				// The symlink is recreated verbatim but never followed while extracting;
+				// resolving hdr.Linkname is left to whoever later reads the extracted tree.
+				// SymlinkAt resolves rel's parent through safepath, so a symlink planted at an
+				// earlier path component is rejected rather than traversed.
+				if err := safepath.SymlinkAt(destRoot, rel, hdr.Linkname); err != nil {
+					return err
+				}
+			default:
+				// Regular files, and GNU/PAX sparse files (archive/tar transparently
+				// materializes sparse holes as zero bytes on Read), are spooled to a temp
+				// file so the reader can move on while a worker performs the write.
+				tmp, err := spoolTarEntry(tr)
+				if err != nil {
+					return err
+				}
+				jobs <- tarJob{rel: rel, mode: os.FileMode(hdr.Mode), tmp: tmp}
+			}
+		}
+	}()
+
+	close(jobs)
+	wg.Wait()
+	if readErr != nil {
+		return readErr
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	for _, link := range links {
+		if err := safepath.LinkAt(destRoot, link.target, link.rel); err != nil {
+			return fmt.Errorf("recreating hardlink %s -> %s: %w", link.rel, link.target, err)
+		}
+	}
+	return nil
+}
+
+// tarEntryRel resolves a tar header's name to a path relative to destDir, rejecting any
+// entry (absolute, or escaping via "..") that would write outside the destination.
+func tarEntryRel(destBase, name string, singleFile bool) (string, error) {
+	if singleFile {
+		return destBase, nil
+	}
+	clean := filepath.Clean(name)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("tar entry %q escapes destination", name)
+	}
+	return filepath.Join(destBase, clean), nil
+}
+
+// spoolTarEntry copies the current tar entry's content to a temp file and returns its path,
+// so the tar reader can advance to the next header without keeping the entry in memory.
+func spoolTarEntry(r io.Reader) (string, error) {
+	tmp, err := ioutil.TempFile("", "argo-untar-")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, r); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// writeTarJob writes a spooled tar entry to its destination via safepath and removes the
+// temp file regardless of outcome.
+func writeTarJob(root *os.File, job tarJob) error {
+	defer os.Remove(job.tmp)
+	data, err := os.Open(job.tmp)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	return writeEntryAt(root, job.rel, data, job.mode)
+}
+
+// writeEntryAt streams r to rel (relative to root) with the given mode, creating any
+// missing parent directories and creating the final write target via safepath.CreateAt so
+// a symlink planted at rel is rejected rather than written through.
+func writeEntryAt(root *os.File, rel string, r io.Reader, mode os.FileMode) error {
+	if dir := filepath.Dir(rel); dir != "." {
+		if err := safepath.MkdirAllAt(root, dir, 0755); err != nil {
+			return err
+		}
+	}
+	out, err := safepath.CreateAt(root, rel, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, r)
+	return err
+}
+
+// unzip extracts a zip file to a destination path, using the same single-file-vs-directory
+// convention as untar. Unlike a tar stream, a zip's central directory lets every entry be
+// opened independently, so directories are created up front and file entries are then
+// streamed to their destinations across a pool of runtime.NumCPU() workers, again bounding
+// memory use to one entry's content per worker rather than the whole archive.
+func unzip(zipPath string, destPath string) error {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	destRoot, err := safepath.Root(filepath.Dir(destPath))
+	if err != nil {
+		return err
+	}
+	defer destRoot.Close()
+	destBase := filepath.Base(destPath)
+
+	fileCount := 0
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() {
+			fileCount++
+		}
+	}
+	singleFile := fileCount == 1 && len(r.File) == 1
+
+	if !singleFile {
+		if err := safepath.MkdirAllAt(destRoot, destBase, 0755); err != nil {
+			return err
+		}
+	}
+
+	var files []*zip.File
+	for _, f := range r.File {
+		rel := destBase
+		if !singleFile {
+			rel = filepath.Join(destBase, filepath.Clean(f.Name))
+		}
+		if f.FileInfo().IsDir() {
+			if err := safepath.MkdirAllAt(destRoot, rel, f.Mode()); err != nil {
+				return err
+			}
+			continue
+		}
+		files = append(files, f)
+	}
+
+	workers := runtime.NumCPU()
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan *zip.File, workers*2)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	recordErr := func(err error) {
+		if err == nil {
+			return
+		}
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				rel := destBase
+				if !singleFile {
+					rel = filepath.Join(destBase, filepath.Clean(f.Name))
+				}
+				recordErr(writeZipFile(destRoot, rel, f))
+			}
+		}()
+	}
+
+	for _, f := range files {
+		jobs <- f
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+func writeZipFile(root *os.File, rel string, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return writeEntryAt(root, rel, rc, f.Mode())
+}
@@ -0,0 +1,39 @@
+package executor
+
+import (
+	"fmt"
+	"path/filepath"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	"github.com/argoproj/argo/workflow/executor/safepath"
+)
+
+// resolveSubPath mirrors the technique kubelet uses for volumeMounts[*].subPath: it
+// validates art.SubPath is a relative path that cannot escape mountPath, then resolves it
+// through safepath so a symlink planted at the subpath (or swapped in after resolution)
+// cannot redirect the artifact onto a different inode. The returned File is a handle on
+// the resolved subpath, suitable for bind-mounting into the container's per-step
+// directory.
+func resolveSubPath(mountPath string, art wfv1.Artifact) (*safepath.File, error) {
+	if art.SubPath == "" {
+		return nil, fmt.Errorf("resolveSubPath: artifact %q has no SubPath", art.Name)
+	}
+	if filepath.IsAbs(art.SubPath) {
+		return nil, fmt.Errorf("%w: subPath %q must be relative", safepath.ErrEscapesRoot, art.SubPath)
+	}
+
+	root, err := safepath.Root(mountPath)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	return safepath.OpenAtNoFollow(root, art.SubPath)
+}
+
+// subPathMountDir is the per-step directory under which resolved artifact subpaths are
+// bind-mounted, keyed by pod UID and container name so concurrent steps (and retries of
+// the same step) never collide.
+func subPathMountDir(podUID, container, artifactName string) string {
+	return filepath.Join("/var/lib/argo/pod-subpaths", podUID, container, artifactName)
+}
@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	"github.com/argoproj/argo/workflow/executor/safepath"
+)
+
+// bindMountSubPath resolves art.SubPath beneath mountPath and bind-mounts the resolved
+// inode read-only onto subPathMountDir(podUID, container, art.Name), returning that
+// bind-mount path. This is the same technique kubelet uses for volumeMounts[*].subPath:
+// binding the already-resolved inode (rather than handing the container runtime a path to
+// re-resolve) means a symlink swap that happens after resolution cannot redirect the mount.
+func bindMountSubPath(podUID, container, mountPath string, art wfv1.Artifact) (string, error) {
+	f, err := resolveSubPath(mountPath, art)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := safepath.StatAt(f)
+	if err != nil {
+		return "", err
+	}
+
+	target := subPathMountDir(podUID, container, art.Name)
+	if info.IsDir() {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return "", err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return "", err
+		}
+		tf, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return "", err
+		}
+		tf.Close()
+	}
+
+	src := f.Path()
+	if err := unix.Mount(src, target, "", unix.MS_BIND, ""); err != nil {
+		return "", fmt.Errorf("bind mount %s -> %s: %w", src, target, err)
+	}
+	if err := unix.Mount("", target, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+		_ = unix.Unmount(target, unix.MNT_DETACH)
+		return "", fmt.Errorf("remount %s read-only: %w", target, err)
+	}
+	return target, nil
+}
+
+// unmountSubPath tears down a bind mount created by bindMountSubPath.
+func unmountSubPath(target string) error {
+	return unix.Unmount(target, unix.MNT_DETACH)
+}
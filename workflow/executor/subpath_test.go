@@ -0,0 +1,73 @@
+package executor
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	"github.com/argoproj/argo/workflow/executor/safepath"
+)
+
+// TestResolveSubPath parallels TestIsBaseImagePath's table style: legal subpaths resolve,
+// and anything that could escape mountPath is rejected.
+func TestResolveSubPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "subpath-test")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "a", "b"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a", "b", "file"), []byte("hi"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "results.txt"), []byte("hi"), 0644))
+
+	tests := []struct {
+		name    string
+		subPath string
+		wantErr bool
+	}{
+		{"legal nested subpath", "a/b/file", false},
+		{"legal directory subpath", "a/b", false},
+		// A single-component subPath resolves directly under mountPath, so
+		// safepath.OpenAtNoFollow's returned File must not share a dirfd with the root
+		// handle resolveSubPath closes on return (see safepath.OpenAtNoFollow).
+		{"legal single-component subpath", "results.txt", false},
+		{"empty subpath is rejected", "", true},
+		{"absolute subpath is rejected", "/etc/passwd", true},
+		{"dot-dot subpath is rejected", "../outside", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			art := wfv1.Artifact{Name: "art", SubPath: test.subPath}
+			f, err := resolveSubPath(dir, art)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			defer f.Close()
+
+			// Using the File after resolveSubPath has returned (and closed its root) is
+			// exactly the use-after-close this case guards against.
+			_, err = safepath.StatAt(f)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestResolveSubPathRejectsSymlinkEscape(t *testing.T) {
+	dir, err := ioutil.TempDir("", "subpath-test")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	assert.NoError(t, os.Symlink("/etc", filepath.Join(dir, "escape")))
+
+	art := wfv1.Artifact{Name: "art", SubPath: "escape/passwd"}
+	_, err = resolveSubPath(dir, art)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, safepath.ErrEscapesRoot))
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package executor
+
+import (
+	"fmt"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+)
+
+func bindMountSubPath(podUID, container, mountPath string, art wfv1.Artifact) (string, error) {
+	return "", fmt.Errorf("subpath bind-mounting is only supported on linux")
+}
+
+func unmountSubPath(target string) error {
+	return fmt.Errorf("subpath bind-mounting is only supported on linux")
+}
@@ -0,0 +1,188 @@
+package executor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+	log "github.com/sirupsen/logrus"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	"github.com/argoproj/argo/workflow/executor/safepath"
+)
+
+// wildcardMatch is a single file matched by a glob artifact pattern, resolved through
+// safepath so the match set can only contain files beneath the artifact's mount root.
+type wildcardMatch struct {
+	relPath string
+	file    *safepath.File
+	info    os.FileInfo
+}
+
+// SaveWildcardArtifact collects every file beneath mountRoot matching art.Path (a pattern
+// containing "*", "?", "[...]" or the doublestar "**" recursive marker) into a single
+// archive. An empty match set produces an empty archive unless art.Optional is false, in
+// which case it is an error.
+func (we *WorkflowExecutor) SaveWildcardArtifact(art wfv1.Artifact, mountRoot string) error {
+	matches, err := we.expandWildcardArtifact(art, mountRoot)
+	if err != nil {
+		return err
+	}
+	defer closeWildcardMatches(matches)
+	if len(matches) == 0 && !art.Optional {
+		return fmt.Errorf("no files matched output artifact pattern %q", art.Path)
+	}
+
+	digest, err := wildcardDigest(matches)
+	if err != nil {
+		return err
+	}
+	log.Infof("wildcard artifact %q matched %d file(s), digest=%s", art.Name, len(matches), digest)
+
+	dest := filepath.Join(os.TempDir(), art.Name+wildcardArchiveExt(art))
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if art.Archive != nil && art.Archive.Zip != nil {
+		return writeWildcardZip(out, matches)
+	}
+	return writeWildcardTar(out, matches)
+}
+
+// expandWildcardArtifact expands art.Path against mountRoot using doublestar, rejecting
+// any match that would resolve outside of mountRoot, and returns the matches sorted by
+// relative path so the result (and therefore its digest) is stable across runs.
+func (we *WorkflowExecutor) expandWildcardArtifact(art wfv1.Artifact, mountRoot string) ([]wildcardMatch, error) {
+	root, err := safepath.Root(mountRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	pattern := art.Path
+	if strings.HasPrefix(pattern, mountRoot) {
+		pattern = strings.TrimPrefix(pattern, mountRoot)
+		pattern = strings.TrimPrefix(pattern, string(filepath.Separator))
+	} else if filepath.IsAbs(pattern) {
+		// The pattern doesn't fall under this mount at all.
+		return nil, nil
+	}
+
+	names, err := doublestar.Glob(filepath.Join(mountRoot, pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid wildcard pattern %q: %w", art.Path, err)
+	}
+
+	matches := make([]wildcardMatch, 0, len(names))
+	for _, name := range names {
+		rel, err := filepath.Rel(mountRoot, name)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			// A match crossing the mount boundary is rejected rather than silently
+			// skipped, since it indicates the pattern (or a symlink it followed)
+			// escaped the artifact's volumeMount.
+			return nil, fmt.Errorf("%w: wildcard match %q crosses mount boundary %q", safepath.ErrEscapesRoot, name, mountRoot)
+		}
+		f, err := safepath.OpenAtNoFollow(root, rel)
+		if err != nil {
+			return nil, err
+		}
+		info, err := safepath.StatAt(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if info.IsDir() {
+			f.Close()
+			continue
+		}
+		matches = append(matches, wildcardMatch{relPath: rel, file: f, info: info})
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].relPath < matches[j].relPath })
+	return matches, nil
+}
+
+// wildcardDigest computes a stable digest over the sorted (relpath, mode, size,
+// sha256(content)) tuples of matches, so that two runs producing the same result set
+// produce the same artifact digest and can share a cache entry.
+func wildcardDigest(matches []wildcardMatch) (string, error) {
+	h := sha256.New()
+	for _, m := range matches {
+		fmt.Fprintf(h, "%s\x00%o\x00%d\x00", m.relPath, m.info.Mode().Perm(), m.info.Size())
+		f, err := os.Open(m.file.Path())
+		if err != nil {
+			return "", err
+		}
+		contentHash := sha256.New()
+		if _, err := io.Copy(contentHash, f); err != nil {
+			f.Close()
+			return "", err
+		}
+		f.Close()
+		h.Write(contentHash.Sum(nil))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeWildcardTar(w io.Writer, matches []wildcardMatch) error {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for _, m := range matches {
+		if err := safepath.TarAt(m.file, tw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeWildcardZip(w io.Writer, matches []wildcardMatch) error {
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+	for _, m := range matches {
+		hdr, err := zip.FileInfoHeader(m.info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = m.relPath
+		hdr.Method = zip.Deflate
+		entry, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(m.file.Path())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(entry, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func closeWildcardMatches(matches []wildcardMatch) {
+	for _, m := range matches {
+		m.file.Close()
+	}
+}
+
+func wildcardArchiveExt(art wfv1.Artifact) string {
+	if art.Archive != nil && art.Archive.Zip != nil {
+		return ".zip"
+	}
+	return ".tar"
+}
@@ -0,0 +1,70 @@
+package executor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+)
+
+func setupWildcardTree(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wildcard-test")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "data", "a", "b"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data", "one.log"), []byte("1"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data", "a", "two.log"), []byte("2"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data", "a", "b", "three.log"), []byte("3"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data", "a", "b", "ignored.txt"), []byte("x"), 0644))
+	return dir
+}
+
+func TestExpandWildcardArtifactRecursive(t *testing.T) {
+	dir := setupWildcardTree(t)
+	we := WorkflowExecutor{}
+
+	art := wfv1.Artifact{Name: "logs", Path: filepath.Join(dir, "data", "**", "*.log")}
+	matches, err := we.expandWildcardArtifact(art, dir)
+	assert.NoError(t, err)
+	defer closeWildcardMatches(matches)
+	assert.Len(t, matches, 3)
+}
+
+func TestExpandWildcardArtifactNoMatches(t *testing.T) {
+	dir := setupWildcardTree(t)
+	we := WorkflowExecutor{}
+
+	art := wfv1.Artifact{Name: "logs", Path: filepath.Join(dir, "data", "*.missing"), Optional: true}
+	matches, err := we.expandWildcardArtifact(art, dir)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 0)
+
+	err = we.SaveWildcardArtifact(art, dir)
+	assert.NoError(t, err)
+
+	art.Optional = false
+	err = we.SaveWildcardArtifact(art, dir)
+	assert.Error(t, err)
+}
+
+func TestExpandWildcardArtifactCrossesMountBoundary(t *testing.T) {
+	dir := setupWildcardTree(t)
+	we := WorkflowExecutor{}
+
+	outside, err := ioutil.TempDir("", "wildcard-outside")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(outside) })
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(outside, "leak.log"), []byte("leak"), 0644))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(dir, "data", "escape")))
+
+	art := wfv1.Artifact{Name: "logs", Path: filepath.Join(dir, "data", "escape", "*.log")}
+	_, err = we.expandWildcardArtifact(art, dir)
+	// a symlinked directory resolves outside dir via filepath.Rel, and is rejected rather
+	// than silently skipped.
+	assert.Error(t, err)
+}